@@ -0,0 +1,213 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package algo
+
+import (
+	"github.com/dgraph-io/dgraph/codec"
+	"github.com/dgraph-io/dgraph/protos/pb"
+)
+
+// This file depends on pb.UidPackDelta and its Op/Insert/CopyRange
+// submessages, which must already be defined in the pb proto schema
+// (generated alongside pb.UidPack) for it to compile; no .proto change is
+// included in this series, since the schema isn't generated from this tree.
+
+// defaultDeltaBlockSize is used for PatchPacked's output when old is nil, the
+// same fallback IntersectSortedPacked uses for an empty input.
+const defaultDeltaBlockSize = 10
+
+// DiffPacked computes a pb.UidPackDelta that turns old into new. It walks
+// both lists with the same two-pointer pattern DifferencePacked already
+// uses: a run of uids present in both lists becomes a single Copy op
+// referencing their position range in old, a run of uids only in new becomes
+// a single Insert op, and uids only in old (deletions) are simply skipped,
+// since PatchPacked only ever reproduces what a Copy or Insert op names.
+func DiffPacked(old, new *pb.UidPack) *pb.UidPackDelta {
+	delta := &pb.UidPackDelta{}
+	if new == nil {
+		// new is empty, so the correct delta has no Ops: nothing to copy from
+		// old, nothing to insert. This is a real diff (PatchPacked applies it
+		// to produce an empty pack), not the "no diff given" case, which is
+		// instead spelled as a nil delta.
+		return delta
+	}
+	if old == nil {
+		old = &pb.UidPack{BlockSize: new.BlockSize}
+	}
+
+	oDec := codec.NewDecoder(old)
+	ouids := oDec.Uids()
+	nDec := codec.NewDecoder(new)
+	nuids := nDec.Uids()
+	oIdx, nIdx := 0, 0
+	oPos := 0 // oPos is old's position in its whole (not per-block) uid sequence.
+
+	var insertBuf []uint64
+	copyStart, copyLen := -1, 0
+
+	flushInsert := func() {
+		if len(insertBuf) == 0 {
+			return
+		}
+		delta.Ops = append(delta.Ops, &pb.UidPackDelta_Op{
+			Insert: &pb.UidPackDelta_Insert{Uids: insertBuf},
+		})
+		insertBuf = nil
+	}
+	flushCopy := func() {
+		if copyStart < 0 {
+			return
+		}
+		delta.Ops = append(delta.Ops, &pb.UidPackDelta_Op{
+			Copy: &pb.UidPackDelta_CopyRange{
+				Start: uint64(copyStart),
+				End:   uint64(copyStart + copyLen),
+			},
+		})
+		copyStart, copyLen = -1, 0
+	}
+	advanceOld := func() {
+		oIdx++
+		oPos++
+	}
+
+	for len(ouids) > 0 && len(nuids) > 0 {
+		if oIdx == len(ouids) {
+			if !oDec.Valid() {
+				break
+			}
+			ouids = oDec.Next()
+			oIdx = 0
+			continue
+		}
+		if nIdx == len(nuids) {
+			if !nDec.Valid() {
+				break
+			}
+			nuids = nDec.Next()
+			nIdx = 0
+			continue
+		}
+
+		switch ov, nv := ouids[oIdx], nuids[nIdx]; {
+		case ov == nv:
+			flushInsert()
+			if copyStart < 0 {
+				copyStart = oPos
+			}
+			copyLen++
+			advanceOld()
+			nIdx++
+		case nv < ov:
+			// nv only exists in new.
+			flushCopy()
+			insertBuf = append(insertBuf, nv)
+			nIdx++
+		default:
+			// ov only exists in old: a deletion, dropped implicitly.
+			flushCopy()
+			advanceOld()
+		}
+	}
+	flushCopy()
+
+	// Anything left in new past the end of old is a pure insert.
+	for {
+		if nIdx == len(nuids) {
+			if !nDec.Valid() {
+				break
+			}
+			nuids = nDec.Next()
+			nIdx = 0
+			continue
+		}
+		insertBuf = append(insertBuf, nuids[nIdx])
+		nIdx++
+	}
+	flushInsert()
+
+	return delta
+}
+
+// PatchPacked applies delta to old, reproducing the new UidPack DiffPacked
+// generated it from. Copy ops are resolved against a single decoder shared
+// across the whole call, since delta's ops reference strictly increasing
+// positions in old and so are always applied left to right.
+//
+// Only a nil delta means "no diff was given, keep old as-is": PatchPacked(old,
+// nil) returns a byte-identical copy of old. A non-nil delta with no Ops is a
+// real diff, not a no-op sentinel: DiffPacked produces exactly that when new
+// is empty (every uid in old was deleted and nothing was inserted), and
+// PatchPacked must apply it literally, returning an empty UidPack.
+func PatchPacked(old *pb.UidPack, delta *pb.UidPackDelta) *pb.UidPack {
+	if delta == nil {
+		return codec.CopyUidPack(old)
+	}
+
+	blockSize := defaultDeltaBlockSize
+	if old != nil && old.BlockSize > 0 {
+		blockSize = int(old.BlockSize)
+	}
+	result := codec.Encoder{BlockSize: blockSize}
+
+	oDec := codec.NewDecoder(old)
+	ouids := oDec.Uids()
+	oIdx, oPos := 0, 0
+
+	next := func() (uint64, bool) {
+		for oIdx == len(ouids) {
+			if !oDec.Valid() {
+				return 0, false
+			}
+			ouids = oDec.Next()
+			oIdx = 0
+		}
+		v := ouids[oIdx]
+		oIdx++
+		oPos++
+		return v, true
+	}
+
+	for _, op := range delta.GetOps() {
+		if ins := op.GetInsert(); ins != nil {
+			for _, uid := range ins.GetUids() {
+				result.Add(uid)
+			}
+			continue
+		}
+
+		cp := op.GetCopy()
+		if cp == nil {
+			continue
+		}
+		start, end := int(cp.GetStart()), int(cp.GetEnd())
+		for oPos < start {
+			if _, ok := next(); !ok {
+				break
+			}
+		}
+		for oPos < end {
+			v, ok := next()
+			if !ok {
+				break
+			}
+			result.Add(v)
+		}
+	}
+
+	return result.Done()
+}
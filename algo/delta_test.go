@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package algo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/dgraph/codec"
+	"github.com/dgraph-io/dgraph/protos/pb"
+)
+
+func TestPatchPackedNilDelta(t *testing.T) {
+	old := buildPack([]uint64{1, 2, 3, 10, 11}, 4)
+	got := PatchPacked(old, nil)
+	want := buildPack([]uint64{1, 2, 3, 10, 11}, 4)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("PatchPacked with a nil delta should be a byte-identical copy: got %+v, want %+v",
+			got, want)
+	}
+}
+
+func TestPatchPackedDeltaWithNoOps(t *testing.T) {
+	// A non-nil delta with no Ops is a real diff, not a no-op sentinel: it's
+	// exactly what DiffPacked(old, nil) produces when every uid in old was
+	// deleted and nothing was inserted, so PatchPacked must apply it
+	// literally and return an empty pack, not old unchanged.
+	old := buildPack([]uint64{1, 2, 3, 10, 11}, 4)
+	got := PatchPacked(old, &pb.UidPackDelta{})
+	if n := len(codecUidsPacked(got)); n != 0 {
+		t.Fatalf("PatchPacked with a delta that has no ops should drop everything, got %d uids", n)
+	}
+}
+
+func TestDiffAndPatchPacked(t *testing.T) {
+	tests := []struct {
+		name string
+		old  []uint64
+		new  []uint64
+	}{
+		{"no change", []uint64{1, 2, 3}, []uint64{1, 2, 3}},
+		{"pure insert", []uint64{1, 5}, []uint64{1, 2, 3, 5}},
+		{"pure delete", []uint64{1, 2, 3, 5}, []uint64{1, 5}},
+		{"insert and delete", []uint64{1, 2, 3, 10}, []uint64{2, 3, 4, 11}},
+		{"empty old", nil, []uint64{1, 2, 3}},
+		{"empty new", []uint64{1, 2, 3}, nil},
+		{"disjoint", []uint64{1, 2, 3}, []uint64{100, 200}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			old := buildPack(tc.old, 4)
+			want := buildPack(tc.new, 4)
+
+			delta := DiffPacked(old, want)
+			got := PatchPacked(old, delta)
+
+			if !reflect.DeepEqual(codecUidsPacked(got), codecUidsPacked(want)) {
+				t.Fatalf("PatchPacked(old, DiffPacked(old, new)) = %v, want %v",
+					codecUidsPacked(got), codecUidsPacked(want))
+			}
+		})
+	}
+}
+
+// codecUidsPacked flattens a UidPack back into a plain uid slice for
+// comparing test results regardless of how they were blocked.
+func codecUidsPacked(u *pb.UidPack) []uint64 {
+	var out []uint64
+	dec := codec.NewDecoder(u)
+	for ; dec.Valid(); dec.Next() {
+		out = append(out, dec.Uids()...)
+	}
+	return out
+}
@@ -18,13 +18,84 @@ package algo
 
 import (
 	"container/heap"
-	"log"
 	"sort"
 
+	"github.com/RoaringBitmap/roaring/roaring64"
+
 	"github.com/dgraph-io/dgraph/codec"
+	"github.com/dgraph-io/dgraph/codec/roaring"
 	"github.com/dgraph-io/dgraph/protos/pb"
 )
 
+// roaringCardinalityThreshold is the combined cardinality (summed over all lists
+// being intersected) at or above which IntersectSortedPacked switches from the linear
+// merge to the Roaring-backed path. It is cheap to decode small lists linearly,
+// but once the lists are large and dense, building Roaring containers and letting
+// them do the galloping/word-at-a-time AND pays for itself.
+const roaringCardinalityThreshold = 100000
+
+// roaringDensityThreshold is the minimum combined density (the summed cardinality
+// of all lists divided by the uid range they span together) for the Roaring path
+// to be picked; the density of each single list is not checked.
+// Sparse lists are cheaper to intersect with the linear/galloping scan because
+// Roaring's array containers degrade to little more than that anyway, while the
+// container bookkeeping adds overhead that isn't recovered.
+const roaringDensityThreshold = 0.05
+
+// shouldUseRoaring decides, from cheap metadata already available on the sorted
+// lists (their cardinality and the uid range they span), whether the combined
+// cardinality and density justify paying for Roaring container construction
+// instead of a linear/galloping merge.
+func shouldUseRoaring(ls []listInfoPacked) bool {
+	total := 0
+	var minUid, maxUid uint64
+	seen := false
+	for _, l := range ls {
+		total += l.length
+		blocks := l.l.GetBlocks()
+		if len(blocks) == 0 {
+			continue
+		}
+		lo := blocks[0].GetBase()
+		hi := lastUidPacked(l.l, blocks)
+		if !seen {
+			minUid, maxUid = lo, hi
+			seen = true
+		}
+		if lo < minUid {
+			minUid = lo
+		}
+		if hi > maxUid {
+			maxUid = hi
+		}
+	}
+	if total < roaringCardinalityThreshold {
+		return false
+	}
+	span := maxUid - minUid + 1
+	if span == 0 {
+		return false
+	}
+	density := float64(total) / float64(span)
+	return density >= roaringDensityThreshold
+}
+
+// lastUidPacked returns the largest uid stored in u, unpacking only the final
+// block of blocks (u.GetBlocks()) rather than decoding the whole pack. Base is
+// a block's first uid, not its last, so callers that need the true max (e.g.
+// shouldUseRoaring's span estimate) can't use the last block's Base directly.
+func lastUidPacked(u *pb.UidPack, blocks []*pb.UidBlock) uint64 {
+	last := blocks[len(blocks)-1]
+	decoder := codec.Decoder{Pack: u}
+	decoder.Seek(last.GetBase(), codec.SeekStart)
+	decoder.UnpackBlock()
+	uids := decoder.Uids()
+	if len(uids) == 0 {
+		return last.GetBase()
+	}
+	return uids[len(uids)-1]
+}
+
 // ApplyFilterPacked applies the filter to a list of packed uids.
 func ApplyFilterPacked(u *pb.UidPack, f func(uint64, int) bool) *pb.UidPack {
 	index := 0
@@ -104,6 +175,83 @@ func IntersectWithLinPacked(u, v *pb.UidPack) *pb.UidPack {
 	return result.Done()
 }
 
+// gallopRatioThreshold is the ratio between the larger and smaller list length
+// (computed with integer division) above which intersectTwoPacked switches
+// from the linear merge to the galloping search. Up to this ratio, decoding
+// both lists in lockstep is competitive with jumping through the larger list
+// block by block.
+const gallopRatioThreshold = 32
+
+// intersectTwoPacked intersects a and b, picking IntersectWithGallopingPacked
+// over IntersectWithLinPacked when the longer list's length divided (integer
+// division) by the shorter list's length exceeds gallopRatioThreshold.
+func intersectTwoPacked(a, b *pb.UidPack) *pb.UidPack {
+	lenA, lenB := codec.ExactLen(a), codec.ExactLen(b)
+	small, large, lenSmall, lenLarge := a, b, lenA, lenB
+	if lenB < lenA {
+		small, large, lenSmall, lenLarge = b, a, lenB, lenA
+	}
+	if lenSmall > 0 && lenLarge/lenSmall > gallopRatioThreshold {
+		return IntersectWithGallopingPacked(small, large)
+	}
+	return IntersectWithLinPacked(a, b)
+}
+
+// IntersectWithGallopingPacked intersects small against large, which is
+// assumed to be orders of magnitude longer, by galloping: for every uid in
+// small it doubles a stride through large until it overshoots, then binary
+// searches the bracketed window. codec.Decoder.Seek is used to jump the
+// decoder across entire skipped blocks of large without unpacking them; only
+// the block that might contain the current binary-search target is ever
+// unpacked. Matches are emitted through a single shared codec.Encoder.
+func IntersectWithGallopingPacked(small, large *pb.UidPack) *pb.UidPack {
+	if small == nil || large == nil {
+		return nil
+	}
+
+	sDec := codec.NewDecoder(small)
+	lDec := codec.Decoder{Pack: large}
+	result := codec.Encoder{BlockSize: int(small.BlockSize)}
+
+	uids := lDec.Uids()
+	pos := 0
+
+	for ; sDec.Valid(); sDec.Next() {
+		for _, x := range sDec.Uids() {
+			// Once the current block of large is exhausted or has fallen
+			// behind x, seek directly to x. Seek skips whole blocks without
+			// unpacking them, only unpacking the block x might live in.
+			if pos >= len(uids) || uids[pos] < x {
+				uids = lDec.Seek(x, codec.SeekStart)
+				pos = 0
+				if len(uids) == 0 {
+					return result.Done()
+				}
+			}
+
+			// Gallop forward from pos, doubling the stride each step, until
+			// we bracket x, then binary search the bracketed window.
+			step := 1
+			lo, hi := pos, pos
+			for hi < len(uids) && uids[hi] < x {
+				lo = hi
+				hi += step
+				step *= 2
+			}
+			if hi > len(uids) {
+				hi = len(uids)
+			}
+			idx := lo + sort.Search(hi-lo, func(i int) bool { return uids[lo+i] >= x })
+			if idx < len(uids) && uids[idx] == x {
+				result.Add(x)
+				idx++
+			}
+			pos = idx
+		}
+	}
+	return result.Done()
+}
+
 // listInfoPacked stores the packed list in a format that allows lists to be sorted by size.
 type listInfoPacked struct {
 	l      *pb.UidPack
@@ -134,11 +282,19 @@ func IntersectSortedPacked(lists []*pb.UidPack) *pb.UidPack {
 		return codec.CopyUidPack(ls[0].l)
 	}
 
+	if shouldUseRoaring(ls) {
+		lists := make([]*pb.UidPack, len(ls))
+		for i, l := range ls {
+			lists[i] = l.l
+		}
+		return IntersectRoaring(lists...)
+	}
+
 	// TODO(martinmr): Add the rest of the algorithms.
-	out := IntersectWithLinPacked(ls[0].l, ls[1].l)
+	out := intersectTwoPacked(ls[0].l, ls[1].l)
 	// Intersect from smallest to largest.
 	for i := 2; i < len(ls); i++ {
-		out := IntersectWithLinPacked(out, ls[i].l)
+		out = intersectTwoPacked(out, ls[i].l)
 		// Break if we reach size 0 as we can no longer
 		// add any element.
 		if codec.ExactLen(out) == 0 {
@@ -331,11 +487,155 @@ func IndexOfPacked(u *pb.UidPack, uid uint64) int {
 	}
 	searchFunc := func(i int) bool { return uids[i] >= uid }
 	uidx := sort.Search(len(uids), searchFunc)
-	log.Printf("uidx %d", uidx)
-	log.Printf("uids %v", uids)
-	if uids[uidx] == uid {
-		return index + uidx
+	if uidx == len(uids) || uids[uidx] != uid {
+		return -1
+	}
+	return index + uidx
+}
+
+// RangePacked returns the uids of u in [lo, hi), for pagination (first,
+// offset, between). It uses codec.Decoder.Seek to skip whole blocks before
+// lo without unpacking them, then stops as soon as it sees a uid >= hi,
+// rather than decoding the whole pack and slicing it.
+func RangePacked(u *pb.UidPack, lo, hi uint64) *pb.UidPack {
+	blockSize := 0
+	if u != nil {
+		blockSize = int(u.BlockSize)
+	}
+	result := codec.Encoder{BlockSize: blockSize}
+	if u == nil || lo >= hi {
+		return result.Done()
+	}
+
+	decoder := codec.Decoder{Pack: u}
+	uids := decoder.Seek(lo, codec.SeekStart)
+	for {
+		for _, uid := range uids {
+			if uid >= hi {
+				return result.Done()
+			}
+			result.Add(uid)
+		}
+		if !decoder.Valid() {
+			break
+		}
+		uids = decoder.Next()
+	}
+	return result.Done()
+}
+
+// SelectPacked returns the k-th (0-indexed) uid in u, for pagination (first,
+// offset, between). It walks Blocks[i].NumUids as a prefix sum to find which
+// block holds index k, without unpacking any block it skips, and only then
+// unpacks the one block it needs.
+func SelectPacked(u *pb.UidPack, k int) (uint64, bool) {
+	if u == nil || k < 0 {
+		return 0, false
+	}
+
+	remaining := k
+	for _, block := range u.Blocks {
+		numUids := int(block.GetNumUids())
+		if remaining >= numUids {
+			remaining -= numUids
+			continue
+		}
+
+		decoder := codec.Decoder{Pack: u}
+		decoder.Seek(block.GetBase(), codec.SeekStart)
+		decoder.UnpackBlock()
+		uids := decoder.Uids()
+		if remaining >= len(uids) {
+			return 0, false
+		}
+		return uids[remaining], true
+	}
+	return 0, false
+}
+
+// roaringBlockSize returns the block size to re-encode with after a Roaring
+// operation, taken from the first list that carries one, falling back to
+// defaultDeltaBlockSize (the same fallback IntersectSortedPacked uses for an
+// empty input) so a Roaring result never ends up one uid per block.
+func roaringBlockSize(lists ...*pb.UidPack) int {
+	for _, l := range lists {
+		if l != nil && l.BlockSize > 0 {
+			return int(l.BlockSize)
+		}
 	}
+	return defaultDeltaBlockSize
+}
 
-	return -1
+// IntersectRoaring calculates the intersection of the given UidPack lists by
+// converting each to a roaring64.Bitmap and ANDing them together. It is picked
+// by IntersectSortedPacked over the linear/galloping paths once the combined
+// cardinality and density of the lists cross roaringCardinalityThreshold and
+// roaringDensityThreshold, since Roaring's word-at-a-time operations amortize
+// better than a merge once the lists are large and dense.
+func IntersectRoaring(lists ...*pb.UidPack) *pb.UidPack {
+	if len(lists) == 0 {
+		encoder := codec.Encoder{BlockSize: 10}
+		return encoder.Done()
+	}
+
+	out := roaring.RoaringFromUidPack(lists[0])
+	for _, l := range lists[1:] {
+		out = roaring64.And(out, roaring.RoaringFromUidPack(l))
+		if out.IsEmpty() {
+			break
+		}
+	}
+	return roaring.UidPackFromRoaring(out, roaringBlockSize(lists...))
+}
+
+// DifferenceRoaring calculates u - v by converting both lists to
+// roaring64.Bitmaps and ANDNOTing them, re-encoding the result as a UidPack.
+func DifferenceRoaring(u, v *pb.UidPack) *pb.UidPack {
+	if u == nil || v == nil {
+		if v == nil {
+			return codec.CopyUidPack(u)
+		}
+		return nil
+	}
+
+	out := roaring64.AndNot(roaring.RoaringFromUidPack(u), roaring.RoaringFromUidPack(v))
+	return roaring.UidPackFromRoaring(out, roaringBlockSize(u, v))
+}
+
+// MergeRoaring unions the given UidPack lists via roaring64.Bitmap ORs,
+// de-duplicating in the process, and re-encodes the result as a UidPack.
+func MergeRoaring(lists []*pb.UidPack) *pb.UidPack {
+	if len(lists) == 0 {
+		return nil
+	}
+
+	out := roaring64.New()
+	for _, l := range lists {
+		if l == nil {
+			continue
+		}
+		out = roaring64.Or(out, roaring.RoaringFromUidPack(l))
+	}
+	return roaring.UidPackFromRoaring(out, roaringBlockSize(lists...))
+}
+
+// ApplyFilterRoaring applies f to every uid in u, keeping those for which it
+// returns true. It behaves like ApplyFilterPacked but walks the uids via a
+// roaring64.Bitmap iterator, which is faster than decoding blocks when u is
+// large and dense enough that IntersectSortedPacked would also pick the
+// Roaring path.
+func ApplyFilterRoaring(u *pb.UidPack, f func(uint64, int) bool) *pb.UidPack {
+	bm := roaring.RoaringFromUidPack(u)
+	encoder := codec.Encoder{BlockSize: roaringBlockSize(u)}
+
+	index := 0
+	itr := bm.Iterator()
+	for itr.HasNext() {
+		uid := itr.Next()
+		if f(uid, index) {
+			encoder.Add(uid)
+		}
+		index++
+	}
+	return encoder.Done()
 }
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package algo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/dgraph/codec"
+	"github.com/dgraph-io/dgraph/protos/pb"
+)
+
+// buildPack encodes a sorted, deduplicated slice of uids into a UidPack with
+// the given block size, for use as benchmark/test fixtures.
+func buildPack(uids []uint64, blockSize int) *pb.UidPack {
+	encoder := codec.Encoder{BlockSize: blockSize}
+	for _, uid := range uids {
+		encoder.Add(uid)
+	}
+	return encoder.Done()
+}
+
+// everyNth returns the sorted uids {1, 1+step, 1+2*step, ...} up to n values,
+// used to build a "small" list that is a sparse subset of a much larger range.
+func everyNth(n, step int) []uint64 {
+	out := make([]uint64, n)
+	for i := 0; i < n; i++ {
+		out[i] = uint64(1 + i*step)
+	}
+	return out
+}
+
+func benchmarkGallopingVsLinear(b *testing.B, ratio int) {
+	const smallLen = 1000
+	small := buildPack(everyNth(smallLen, ratio), 64)
+	large := buildPack(everyNth(smallLen*ratio, 1), 64)
+
+	b.Run("Galloping", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			IntersectWithGallopingPacked(small, large)
+		}
+	})
+	b.Run("Linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			IntersectWithLinPacked(small, large)
+		}
+	})
+}
+
+func BenchmarkIntersect100to1(b *testing.B) {
+	benchmarkGallopingVsLinear(b, 100)
+}
+
+func BenchmarkIntersect10000to1(b *testing.B) {
+	benchmarkGallopingVsLinear(b, 10000)
+}
+
+func TestIntersectWithGallopingPacked(t *testing.T) {
+	checkAgainstLinear := func(t *testing.T, small, large *pb.UidPack) {
+		t.Helper()
+		got := codecUidsPacked(IntersectWithGallopingPacked(small, large))
+		want := codecUidsPacked(IntersectWithLinPacked(small, large))
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("IntersectWithGallopingPacked = %v, want %v (IntersectWithLinPacked)", got, want)
+		}
+	}
+
+	t.Run("100 to 1 ratio", func(t *testing.T) {
+		small := buildPack(everyNth(1000, 100), 64)
+		large := buildPack(everyNth(100000, 1), 64)
+		checkAgainstLinear(t, small, large)
+	})
+
+	t.Run("10000 to 1 ratio", func(t *testing.T) {
+		small := buildPack(everyNth(100, 10000), 64)
+		large := buildPack(everyNth(1000000, 1), 64)
+		checkAgainstLinear(t, small, large)
+	})
+
+	t.Run("target in first block of large", func(t *testing.T) {
+		small := buildPack([]uint64{1, 2}, 4)
+		large := buildPack(everyNth(1000, 1), 4)
+		checkAgainstLinear(t, small, large)
+	})
+
+	t.Run("target in last block of large", func(t *testing.T) {
+		small := buildPack([]uint64{997, 998}, 4)
+		large := buildPack(everyNth(1000, 1), 4)
+		checkAgainstLinear(t, small, large)
+	})
+
+	t.Run("no matches", func(t *testing.T) {
+		small := buildPack([]uint64{5, 15, 25}, 4)
+		large := buildPack(everyNth(1000, 10), 4)
+		checkAgainstLinear(t, small, large)
+	})
+
+	t.Run("empty small", func(t *testing.T) {
+		small := buildPack(nil, 4)
+		large := buildPack(everyNth(1000, 1), 4)
+		checkAgainstLinear(t, small, large)
+	})
+
+	t.Run("empty large", func(t *testing.T) {
+		small := buildPack([]uint64{1, 2, 3}, 4)
+		large := buildPack(nil, 4)
+		checkAgainstLinear(t, small, large)
+	})
+}
+
+func TestIndexOfPackedMiss(t *testing.T) {
+	u := buildPack([]uint64{1, 2, 3, 10, 11, 12}, 3)
+
+	if idx := IndexOfPacked(u, 5); idx != -1 {
+		t.Fatalf("IndexOfPacked(5) = %d, want -1", idx)
+	}
+	// A miss past the last uid in the pack returns -1 instead of indexing uids.
+	if idx := IndexOfPacked(u, 100); idx != -1 {
+		t.Fatalf("IndexOfPacked(100) = %d, want -1", idx)
+	}
+	if idx := IndexOfPacked(u, 11); idx != 4 {
+		t.Fatalf("IndexOfPacked(11) = %d, want 4", idx)
+	}
+}
+
+func TestRangePacked(t *testing.T) {
+	u := buildPack([]uint64{1, 2, 3, 10, 11, 12, 20}, 3)
+
+	got := codecUidsPacked(RangePacked(u, 3, 12))
+	want := []uint64{3, 10, 11}
+	if len(got) != len(want) {
+		t.Fatalf("RangePacked(3, 12) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("RangePacked(3, 12) = %v, want %v", got, want)
+		}
+	}
+
+	if got := codecUidsPacked(RangePacked(u, 100, 200)); len(got) != 0 {
+		t.Fatalf("RangePacked(100, 200) = %v, want empty", got)
+	}
+}
+
+func TestSelectPacked(t *testing.T) {
+	uids := []uint64{1, 2, 3, 10, 11, 12, 20}
+	u := buildPack(uids, 3)
+
+	for k, want := range uids {
+		got, ok := SelectPacked(u, k)
+		if !ok || got != want {
+			t.Fatalf("SelectPacked(%d) = (%d, %v), want (%d, true)", k, got, ok, want)
+		}
+	}
+
+	if _, ok := SelectPacked(u, len(uids)); ok {
+		t.Fatalf("SelectPacked(%d) should be out of range", len(uids))
+	}
+}
+
+func TestIntersectSortedPackedThreeLists(t *testing.T) {
+	// Regression test: IntersectSortedPacked used to shadow its running
+	// result with := inside the loop, so any list past the first two was
+	// computed and discarded, always returning just ls[0] ∩ ls[1].
+	a := buildPack([]uint64{1, 2, 3, 4, 5}, 4)
+	b := buildPack([]uint64{2, 3, 4, 6}, 4)
+	c := buildPack([]uint64{3, 4, 7}, 4)
+
+	got := codecUidsPacked(IntersectSortedPacked([]*pb.UidPack{a, b, c}))
+	want := []uint64{3, 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("IntersectSortedPacked(a, b, c) = %v, want %v", got, want)
+	}
+}
+
+func TestIntersectRoaring(t *testing.T) {
+	a := buildPack([]uint64{1, 2, 3, 10, 11}, 4)
+	b := buildPack([]uint64{2, 3, 11, 20}, 4)
+
+	got := codecUidsPacked(IntersectRoaring(a, b))
+	want := []uint64{2, 3, 11}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("IntersectRoaring = %v, want %v", got, want)
+	}
+}
+
+func TestDifferenceRoaring(t *testing.T) {
+	a := buildPack([]uint64{1, 2, 3, 10, 11}, 4)
+	b := buildPack([]uint64{2, 3, 11, 20}, 4)
+
+	got := codecUidsPacked(DifferenceRoaring(a, b))
+	want := []uint64{1, 10}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DifferenceRoaring = %v, want %v", got, want)
+	}
+}
+
+func TestMergeRoaring(t *testing.T) {
+	a := buildPack([]uint64{1, 3, 5}, 4)
+	b := buildPack([]uint64{2, 3, 6}, 4)
+
+	got := codecUidsPacked(MergeRoaring([]*pb.UidPack{a, b}))
+	want := []uint64{1, 2, 3, 5, 6}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("MergeRoaring = %v, want %v", got, want)
+	}
+}
+
+func TestShouldUseRoaringUsesLastUidNotBlockBase(t *testing.T) {
+	// A single block whose Base is far below the span-defining max uid: if
+	// shouldUseRoaring mistakenly used the last block's Base as the max uid
+	// instead of the true last uid, it would compute a much smaller span and
+	// an inflated density, crossing roaringDensityThreshold when it shouldn't.
+	blockSize := roaringCardinalityThreshold
+	uids := make([]uint64, 0, roaringCardinalityThreshold)
+	for i := uint64(0); i < roaringCardinalityThreshold; i++ {
+		// One uid in 100, so the true density is below roaringDensityThreshold,
+		// and all uids fit in one block, so its Base (the block's first uid)
+		// sits far from the pack's true last uid.
+		uids = append(uids, 1+i*100)
+	}
+	pack := buildPack(uids, blockSize)
+	ls := []listInfoPacked{{l: pack, length: codec.ExactLen(pack)}}
+
+	if shouldUseRoaring(ls) {
+		t.Fatalf("shouldUseRoaring = true for a sparse list, want false")
+	}
+}